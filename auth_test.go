@@ -0,0 +1,72 @@
+package teamcity
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRefreshingAuthenticator_RefreshesOn401(t *testing.T) {
+	refreshCalls := 0
+	auth := &RefreshingAuthenticator{
+		Authenticator: BearerToken{Token: "old"},
+		Refresh: func() (Authenticator, error) {
+			refreshCalls++
+			return BearerToken{Token: "new"}, nil
+		},
+	}
+
+	requests := 0
+	client := &Client{
+		auth: auth,
+		host: "host.example.com",
+		HTTPClient: &http.Client{
+			Transport: RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+				requests++
+				if req.Header.Get("Authorization") != "Bearer new" {
+					return newResponse(http.StatusUnauthorized, "unauthorized"), nil
+				}
+				return newResponse(http.StatusOK, "{}"), nil
+			}),
+		},
+	}
+
+	_, err := client.doNotJSONRequest(context.Background(), "GET", "/app/rest/builds/id:1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if refreshCalls != 1 {
+		t.Fatalf("refreshCalls = %d, want 1", refreshCalls)
+	}
+	if requests != 2 {
+		t.Fatalf("requests = %d, want 2 (initial 401 + retry with refreshed token)", requests)
+	}
+}
+
+func TestRefreshingAuthenticator_NoRefreshWhenNotNeeded(t *testing.T) {
+	refreshCalls := 0
+	auth := &RefreshingAuthenticator{
+		Authenticator: BearerToken{Token: "good"},
+		Refresh: func() (Authenticator, error) {
+			refreshCalls++
+			return BearerToken{Token: "good"}, nil
+		},
+	}
+
+	client := &Client{
+		auth: auth,
+		host: "host.example.com",
+		HTTPClient: &http.Client{
+			Transport: RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return newResponse(http.StatusOK, "{}"), nil
+			}),
+		},
+	}
+
+	if _, err := client.doNotJSONRequest(context.Background(), "GET", "/app/rest/builds/id:1", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if refreshCalls != 0 {
+		t.Fatalf("refreshCalls = %d, want 0", refreshCalls)
+	}
+}
@@ -2,6 +2,7 @@ package teamcity
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,22 +12,31 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/habracoder/teamcity/report"
 )
 
 // Client to access a TeamCity API
 type Client struct {
-	HTTPClient *http.Client
-	username   string
-	password   string
-	host       string
-	debug      bool
+	HTTPClient     *http.Client
+	auth           Authenticator
+	host           string
+	debug          bool
+	defaultTimeout time.Duration
+	tracer         RequestTracer
+	logger         Logger
 }
 
 func New(host, username, password string) *Client {
+	return NewWithAuth(host, BasicAuth{User: username, Pass: password})
+}
+
+// NewWithAuth builds a Client authenticating with auth instead of the
+// classic username/password Basic auth, e.g. BearerToken or GuestAuth.
+func NewWithAuth(host string, auth Authenticator) *Client {
 	return &Client{
 		HTTPClient: http.DefaultClient,
-		username:   username,
-		password:   password,
+		auth:       auth,
 		host:       host,
 	}
 }
@@ -35,7 +45,52 @@ func (c *Client) SetDebug(debug bool) {
 	c.debug = debug
 }
 
+// SetRequestTracer installs a hook that observes every outgoing request
+// and its response, e.g. FileTracer. The tracer is only invoked while
+// debug mode is enabled (see SetDebug).
+func (c *Client) SetRequestTracer(tracer RequestTracer) {
+	c.tracer = tracer
+}
+
+// SetLogger redirects retry diagnostics (normally printed via the
+// standard library's log package) to logger, so embedding applications
+// can route them through their own structured logger.
+func (c *Client) SetLogger(logger Logger) {
+	c.logger = logger
+}
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.logger != nil {
+		c.logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// SetDefaultTimeout bounds the total wall time of any context-less call
+// (QueueBuild, GetBuild, etc.) by wrapping it in a context.WithTimeout.
+// Calls made through the *Context variants are unaffected; pass your own
+// context there if you need a deadline.
+func (c *Client) SetDefaultTimeout(timeout time.Duration) {
+	c.defaultTimeout = timeout
+}
+
+// defaultContext builds the context used by context-less methods: either
+// context.Background(), or a context.WithTimeout bounded by defaultTimeout.
+func (c *Client) defaultContext() (context.Context, context.CancelFunc) {
+	if c.defaultTimeout > 0 {
+		return context.WithTimeout(context.Background(), c.defaultTimeout)
+	}
+	return context.Background(), func() {}
+}
+
 func (c *Client) QueueBuild(buildTypeID string, branchName string, properties map[string]string) (*Build, error) {
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.QueueBuildContext(ctx, buildTypeID, branchName, properties)
+}
+
+func (c *Client) QueueBuildContext(ctx context.Context, buildTypeID string, branchName string, properties map[string]string) (*Build, error) {
 	jsonQuery := struct {
 		BuildTypeID string `json:"buildTypeId,omitempty"`
 		Properties  struct {
@@ -57,8 +112,8 @@ func (c *Client) QueueBuild(buildTypeID string, branchName string, properties ma
 	build := &Build{}
 
 	retries := 8
-	err := withRetry(retries, func() error {
-		return c.doRequest("POST", "/httpAuth/app/rest/buildQueue", jsonQuery, &build)
+	err := c.withRetry(ctx, retries, func() error {
+		return c.doRequest(ctx, "POST", "/app/rest/buildQueue", jsonQuery, &build)
 	})
 	if err != nil {
 		return nil, err
@@ -70,15 +125,21 @@ func (c *Client) QueueBuild(buildTypeID string, branchName string, properties ma
 }
 
 func (c *Client) SearchBuild(locator string) ([]*Build, error) {
-	path := fmt.Sprintf("/httpAuth/app/rest/builds/?locator=%s&fields=count,build(*,tags(tag),triggered(*),properties(property),problemOccurrences(*,problemOccurrence(*)),testOccurrences(*,testOccurrence(*)),changes(*,change(*)))", locator)
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.SearchBuildContext(ctx, locator)
+}
+
+func (c *Client) SearchBuildContext(ctx context.Context, locator string) ([]*Build, error) {
+	path := fmt.Sprintf("/app/rest/builds/?locator=%s&fields=count,build(*,tags(tag),triggered(*),properties(property),problemOccurrences(*,problemOccurrence(*)),testOccurrences(*,testOccurrence(*)),changes(*,change(*)))", locator)
 
 	respStruct := struct {
 		Count int
 		Build []*Build
 	}{}
 	retries := 8
-	err := withRetry(retries, func() error {
-		return c.doRequest("GET", path, nil, &respStruct)
+	err := c.withRetry(ctx, retries, func() error {
+		return c.doRequest(ctx, "GET", path, nil, &respStruct)
 	})
 	if err != nil {
 		return nil, err
@@ -92,8 +153,14 @@ func (c *Client) SearchBuild(locator string) ([]*Build, error) {
 }
 
 func (c *Client) GetQueuedBuilds(locator string) ([]*Build, error) {
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.GetQueuedBuildsContext(ctx, locator)
+}
+
+func (c *Client) GetQueuedBuildsContext(ctx context.Context, locator string) ([]*Build, error) {
 	path := fmt.Sprintf(
-		"/httpAuth/app/rest/buildQueue?locator=%s&fields="+
+		"/app/rest/buildQueue?locator=%s&fields="+
 		"count,"+
 		"build(" +
 			"*," +
@@ -111,8 +178,8 @@ func (c *Client) GetQueuedBuilds(locator string) ([]*Build, error) {
 		Build []*Build
 	}{}
 	retries := 8
-	err := withRetry(retries, func() error {
-		return c.doRequest("GET", path, nil, &respStruct)
+	err := c.withRetry(ctx, retries, func() error {
+		return c.doRequest(ctx, "GET", path, nil, &respStruct)
 	})
 	if err != nil {
 		return nil, err
@@ -126,12 +193,18 @@ func (c *Client) GetQueuedBuilds(locator string) ([]*Build, error) {
 }
 
 func (c *Client) GetBuild(buildID string) (*Build, error) {
-	path := fmt.Sprintf("/httpAuth/app/rest/builds/id:%s?fields=*,tags(tag),triggered(*),properties(property),problemOccurrences(*,problemOccurrence(*)),testOccurrences(*,testOccurrence(*)),changes(*,change(*))", buildID)
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.GetBuildContext(ctx, buildID)
+}
+
+func (c *Client) GetBuildContext(ctx context.Context, buildID string) (*Build, error) {
+	path := fmt.Sprintf("/app/rest/builds/id:%s?fields=*,tags(tag),triggered(*),properties(property),problemOccurrences(*,problemOccurrence(*)),testOccurrences(*,testOccurrence(*)),changes(*,change(*))", buildID)
 	var build *Build
 
 	retries := 8
-	err := withRetry(retries, func() error {
-		return c.doRequest("GET", path, nil, &build)
+	err := c.withRetry(ctx, retries, func() error {
+		return c.doRequest(ctx, "GET", path, nil, &build)
 	})
 
 	if err != nil {
@@ -146,6 +219,12 @@ func (c *Client) GetBuild(buildID string) (*Build, error) {
 }
 
 func (c *Client) GetBuildID(buildTypeID, branchName, buildNumber string) (string, error) {
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.GetBuildIDContext(ctx, buildTypeID, branchName, buildNumber)
+}
+
+func (c *Client) GetBuildIDContext(ctx context.Context, buildTypeID, branchName, buildNumber string) (string, error) {
 	type builds struct {
 		Count    int
 		Href     string
@@ -153,12 +232,12 @@ func (c *Client) GetBuildID(buildTypeID, branchName, buildNumber string) (string
 		Build    []Build
 	}
 
-	path := fmt.Sprintf("/httpAuth/app/rest/buildTypes/id:%s/builds?locator=branch:%s,number:%s,count:1", buildTypeID, branchName, buildNumber)
+	path := fmt.Sprintf("/app/rest/buildTypes/id:%s/builds?locator=branch:%s,number:%s,count:1", buildTypeID, branchName, buildNumber)
 
 	var build *builds
 	retries := 8
-	err := withRetry(retries, func() error {
-		return c.doRequest("GET", path, nil, &build)
+	err := c.withRetry(ctx, retries, func() error {
+		return c.doRequest(ctx, "GET", path, nil, &build)
 	})
 	if err != nil {
 		return "ID not found", err
@@ -172,15 +251,21 @@ func (c *Client) GetBuildID(buildTypeID, branchName, buildNumber string) (string
 }
 
 func (c *Client) GetBuildProperties(buildID string) (map[string]string, error) {
-	path := fmt.Sprintf("/httpAuth/app/rest/builds/id:%s/resulting-properties", buildID)
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.GetBuildPropertiesContext(ctx, buildID)
+}
+
+func (c *Client) GetBuildPropertiesContext(ctx context.Context, buildID string) (map[string]string, error) {
+	path := fmt.Sprintf("/app/rest/builds/id:%s/resulting-properties", buildID)
 
 	var response struct {
 		Property []oneProperty `json:"property,omitempty"`
 	}
 
 	retries := 8
-	err := withRetry(retries, func() error {
-		return c.doRequest("GET", path, nil, &response)
+	err := c.withRetry(ctx, retries, func() error {
+		return c.doRequest(ctx, "GET", path, nil, &response)
 	})
 	if err != nil {
 		return nil, err
@@ -194,12 +279,18 @@ func (c *Client) GetBuildProperties(buildID string) (map[string]string, error) {
 }
 
 func (c *Client) GetChanges(path string) ([]Change, error) {
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.GetChangesContext(ctx, path)
+}
+
+func (c *Client) GetChangesContext(ctx context.Context, path string) ([]Change, error) {
 	var changes struct {
 		Change []Change
 	}
 
 	path += ",count:99999"
-	err := c.doRequest("GET", path, nil, &changes)
+	err := c.doRequest(ctx, "GET", path, nil, &changes)
 	if err != nil {
 		return nil, err
 	}
@@ -212,6 +303,12 @@ func (c *Client) GetChanges(path string) ([]Change, error) {
 }
 
 func (c *Client) GetProblems(path string, count int64) ([]ProblemOccurrence, error) {
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.GetProblemsContext(ctx, path, count)
+}
+
+func (c *Client) GetProblemsContext(ctx context.Context, path string, count int64) ([]ProblemOccurrence, error) {
 	var problems struct {
 		Count             int64
 		Default           bool
@@ -219,7 +316,7 @@ func (c *Client) GetProblems(path string, count int64) ([]ProblemOccurrence, err
 	}
 
 	path += fmt.Sprintf(",count:%v&fields=*,problemOccurrence(*,details)", count)
-	err := c.doRequest("GET", path, nil, &problems)
+	err := c.doRequest(ctx, "GET", path, nil, &problems)
 	if err != nil {
 		return nil, err
 	}
@@ -232,6 +329,12 @@ func (c *Client) GetProblems(path string, count int64) ([]ProblemOccurrence, err
 }
 
 func (c *Client) GetTests(path string, count int64, failingOnly bool, ignoreMuted bool) ([]TestOccurrence, error) {
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.GetTestsContext(ctx, path, count, failingOnly, ignoreMuted)
+}
+
+func (c *Client) GetTestsContext(ctx context.Context, path string, count int64, failingOnly bool, ignoreMuted bool) ([]TestOccurrence, error) {
 	var tests struct {
 		Count          int64
 		HREF           string
@@ -245,7 +348,7 @@ func (c *Client) GetTests(path string, count int64, failingOnly bool, ignoreMute
 		path += ",status:FAILURE"
 	}
 	path += fmt.Sprintf(",count:%v", count)
-	err := c.doRequest("GET", path, nil, &tests)
+	err := c.doRequest(ctx, "GET", path, nil, &tests)
 	if err != nil {
 		return nil, err
 	}
@@ -254,28 +357,102 @@ func (c *Client) GetTests(path string, count int64, failingOnly bool, ignoreMute
 }
 
 func (c *Client) CancelBuild(buildID int64, comment string) error {
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.CancelBuildContext(ctx, buildID, comment)
+}
+
+func (c *Client) CancelBuildContext(ctx context.Context, buildID int64, comment string) error {
 	body := map[string]interface{}{
 		"buildCancelRequest": map[string]interface{}{
 			"comment":       comment,
 			"readIntoQueue": true,
 		},
 	}
-	return c.doRequest("POST", fmt.Sprintf("/httpAuth/app/rest/id:%d", buildID), body, nil)
+	return c.doRequest(ctx, "POST", fmt.Sprintf("/app/rest/id:%d", buildID), body, nil)
 }
 
 func (c *Client) GetBuildLog(buildID string) (string, error) {
-	cnt, err := c.doNotJSONRequest("GET", fmt.Sprintf("/httpAuth/downloadBuildLog.html?buildId=%s", buildID), nil)
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.GetBuildLogContext(ctx, buildID)
+}
+
+func (c *Client) GetBuildLogContext(ctx context.Context, buildID string) (string, error) {
+	cnt, err := c.doNotJSONRequest(ctx, "GET", fmt.Sprintf("/downloadBuildLog.html?buildId=%s", buildID), nil)
 	buf := bytes.NewBuffer(cnt)
 	return buf.String(), err
 }
 
-func (c *Client) doRequest(method string, path string, data interface{}, v interface{}) error {
-	jsonCnt, err := c.doNotJSONRequest(method, path, data)
+// GetBuildReport fetches the build, its tests and its problems in one
+// shot and streams them out re-encoded as format (JUnit XML or SubUnit
+// v2), so callers can feed TeamCity results into JUnit-consuming systems
+// without writing their own glue.
+func (c *Client) GetBuildReport(buildID string, format report.Format) (io.ReadCloser, error) {
+	ctx, cancel := c.defaultContext()
+	defer cancel()
+	return c.GetBuildReportContext(ctx, buildID, format)
+}
+
+func (c *Client) GetBuildReportContext(ctx context.Context, buildID string, format report.Format) (io.ReadCloser, error) {
+	build, err := c.GetBuildContext(ctx, buildID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	tests, err := c.GetTestsContext(ctx, fmt.Sprintf("/app/rest/testOccurrences?locator=build:(id:%s)", buildID), 99999, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	problems, err := c.GetProblemsContext(ctx, fmt.Sprintf("/app/rest/problemOccurrences?locator=build:(id:%s)", buildID), 99999)
+	if err != nil {
+		return nil, err
+	}
+
+	buildInfo := report.BuildInfo{BuildTypeID: build.BuildTypeID}
+
+	testResults := make([]report.TestResult, len(tests))
+	for i, t := range tests {
+		testResults[i] = report.TestResult{
+			Name:     t.Name,
+			Status:   t.Status,
+			Duration: t.Duration,
+			Details:  t.Details,
+		}
 	}
 
-	ioutil.WriteFile(fmt.Sprintf("/tmp/mama-%s.json", time.Now().Format("15h04m05.000")), jsonCnt, 0644)
+	problemResults := make([]report.ProblemResult, len(problems))
+	for i, p := range problems {
+		problemResults[i] = report.ProblemResult{
+			Type:     p.Type,
+			Identity: p.Identity,
+			Details:  p.Details,
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		var err error
+		switch format {
+		case report.FormatJUnitXML:
+			err = report.WriteJUnitXML(pw, buildInfo, testResults, problemResults)
+		case report.FormatSubunitV2:
+			err = report.WriteSubunitV2(pw, buildInfo, testResults, problemResults)
+		default:
+			err = fmt.Errorf("teamcity: unknown report format %v", format)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, method string, path string, data interface{}, v interface{}) error {
+	jsonCnt, err := c.doNotJSONRequest(ctx, method, path, data)
+	if err != nil {
+		return err
+	}
 
 	if v != nil {
 		err = json.Unmarshal(jsonCnt, &v)
@@ -301,25 +478,73 @@ func (c *Client) addProtocol(path string) string {
 	return fmt.Sprintf("%s%s%s", prefix, host, path)
 }
 
-func (c *Client) doNotJSONRequest(method string, path string, data interface{}) ([]byte, error) {
-	authURL := c.addProtocol(path)
-
-	if c.debug {
-		fmt.Printf("Sending request to %s\n", authURL)
+// authPrefix returns the REST path prefix appropriate for c.auth
+// ("/httpAuth", "", "/guestAuth", ...).
+func (c *Client) authPrefix() string {
+	if p, ok := c.auth.(authPathPrefixer); ok {
+		return p.authPathPrefix()
 	}
+	return "/httpAuth"
+}
 
-	var body io.Reader
+func (c *Client) doNotJSONRequest(ctx context.Context, method string, path string, data interface{}) ([]byte, error) {
+	var jsonReq []byte
 	if data != nil {
-		jsonReq, err := json.Marshal(data)
+		var err error
+		jsonReq, err = json.Marshal(data)
 		if err != nil {
 			return nil, fmt.Errorf("marshaling data: %s", err)
 		}
+	}
+
+	respBody, statusCode, err := c.sendRequest(ctx, method, path, jsonReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode == http.StatusUnauthorized {
+		if refresher, ok := c.auth.(*RefreshingAuthenticator); ok {
+			if rerr := refresher.refreshOn401(); rerr == nil {
+				respBody, statusCode, err = c.sendRequest(ctx, method, path, jsonReq)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if statusCode < 200 || statusCode >= 300 {
+		return nil, newResponseError(statusCode, path, respBody)
+	}
+
+	return respBody, nil
+}
 
+// sendRequest issues a single HTTP request for path under c.authPrefix(),
+// applying c.auth, and returns the raw response body and status code
+// without interpreting them.
+func (c *Client) sendRequest(ctx context.Context, method string, path string, jsonReq []byte) ([]byte, int, error) {
+	authURL := c.addProtocol(c.authPrefix() + path)
+
+	if c.debug {
+		fmt.Printf("Sending request to %s\n", authURL)
+		if c.tracer != nil {
+			c.tracer.TraceRequest(method, authURL, jsonReq)
+		}
+	}
+
+	var body io.Reader
+	if jsonReq != nil {
 		body = bytes.NewBuffer(jsonReq)
 	}
 
-	req, _ := http.NewRequest(method, authURL, body)
-	req.SetBasicAuth(c.username, c.password)
+	req, err := http.NewRequestWithContext(ctx, method, authURL, body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("building request: %s", err)
+	}
+	if err := c.auth.Apply(req); err != nil {
+		return nil, 0, fmt.Errorf("applying authenticator: %s", err)
+	}
 	req.Header.Add("Accept", "application/json")
 
 	if body != nil {
@@ -328,11 +553,26 @@ func (c *Client) doNotJSONRequest(method string, path string, data interface{})
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return nil, err
+		if c.debug && c.tracer != nil {
+			c.tracer.TraceResponse(0, nil, err)
+		}
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
-	return ioutil.ReadAll(resp.Body)
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		if c.debug && c.tracer != nil {
+			c.tracer.TraceResponse(resp.StatusCode, nil, err)
+		}
+		return nil, 0, err
+	}
+
+	if c.debug && c.tracer != nil {
+		c.tracer.TraceResponse(resp.StatusCode, respBody, nil)
+	}
+
+	return respBody, resp.StatusCode, nil
 }
 
 func truncate(s string, l int) string {
@@ -342,14 +582,32 @@ func truncate(s string, l int) string {
 	return s
 }
 
-func withRetry(retries int, f func() error) (err error) {
+// withRetry calls f up to retries times, stopping early if ctx is done, if
+// f fails with context.Canceled/context.DeadlineExceeded, or if f fails
+// with a non-retryable 4xx *Error (anything but 408/429, which are
+// transient and worth retrying).
+func (c *Client) withRetry(ctx context.Context, retries int, f func() error) (err error) {
 	for i := 0; i < retries; i++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		err = f()
-		if err != nil {
-			log.Printf("Retry: %v / %v, error: %v\n", i, retries, err)
-		} else {
-			return
+		if err == nil {
+			return nil
+		}
+
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
 		}
+
+		var tcErr *Error
+		if errors.As(err, &tcErr) && tcErr.StatusCode >= 400 && tcErr.StatusCode < 500 &&
+			tcErr.StatusCode != http.StatusRequestTimeout && tcErr.StatusCode != http.StatusTooManyRequests {
+			return err
+		}
+
+		c.logf("Retry: %v / %v, error: %v\n", i, retries, err)
 	}
 	return
 }
@@ -0,0 +1,85 @@
+package teamcity
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWithRetry_ReturnsPromptlyOnContextCancelMidRetry(t *testing.T) {
+	client := &Client{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+
+	err := client.withRetry(ctx, 10, func() error {
+		calls++
+		cancel()
+		return errors.New("transient failure")
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Fatalf("f was called %d times, want 1 (withRetry should stop as soon as ctx is done)", calls)
+	}
+}
+
+func TestWithRetry_ReturnsCtxErrWhenAlreadyDone(t *testing.T) {
+	client := &Client{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := client.withRetry(ctx, 10, func() error {
+		calls++
+		return nil
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if calls != 0 {
+		t.Fatalf("f was called %d times, want 0 (ctx was already done)", calls)
+	}
+}
+
+func TestSetDefaultTimeout_BoundsContextlessCalls(t *testing.T) {
+	client := &Client{
+		auth: BasicAuth{User: "u", Pass: "p"},
+		host: "host.example.com",
+		HTTPClient: &http.Client{
+			Transport: RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+				<-req.Context().Done()
+				return nil, req.Context().Err()
+			}),
+		},
+	}
+	client.SetDefaultTimeout(10 * time.Millisecond)
+
+	start := time.Now()
+	_, err := client.SearchBuild("id:1")
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("SearchBuild took %v, want it to return promptly once the default timeout elapses", elapsed)
+	}
+}
+
+func TestDefaultContext_NoTimeoutWhenUnset(t *testing.T) {
+	client := &Client{}
+
+	ctx, cancel := client.defaultContext()
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatalf("defaultContext() has a deadline, want none when SetDefaultTimeout was never called")
+	}
+}
@@ -0,0 +1,93 @@
+package teamcity
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+)
+
+// Error is returned for any non-2xx TeamCity REST response. StatusCode is
+// always set; Code/Message/Details are populated on a best-effort basis
+// from whatever error body TeamCity returned (XML, JSON, or plain text).
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    string
+	Path       string
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("teamcity: %s (status %d, path %s)", e.Message, e.StatusCode, e.Path)
+	}
+	return fmt.Sprintf("teamcity: request to %s failed with status %d", e.Path, e.StatusCode)
+}
+
+// Is lets errors.Is(err, teamcity.ErrNotFound) and friends work against an
+// *Error by comparing status codes/classes rather than identity.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// Sentinel errors for use with errors.Is. They carry no data themselves;
+// (*Error).Is matches them against the status code of the actual error.
+var (
+	ErrNotFound     = fmt.Errorf("teamcity: not found")
+	ErrUnauthorized = fmt.Errorf("teamcity: unauthorized")
+	ErrForbidden    = fmt.Errorf("teamcity: forbidden")
+	ErrServer       = fmt.Errorf("teamcity: server error")
+	ErrRateLimited  = fmt.Errorf("teamcity: rate limited")
+)
+
+// teamCityErrorBody mirrors the XML error payload TeamCity returns for
+// many REST failures, e.g. <errors><error>message</error></errors>.
+type teamCityErrorBody struct {
+	XMLName xml.Name `xml:"errors"`
+	Errors  []string `xml:"error"`
+}
+
+// teamCityErrorJSON covers the JSON shape used by some endpoints, e.g.
+// {"error": "...", "errorCode": "...", "details": "..."}.
+type teamCityErrorJSON struct {
+	Error   string `json:"error"`
+	Code    string `json:"errorCode"`
+	Details string `json:"details"`
+}
+
+// newResponseError builds an *Error from a non-2xx response, attempting to
+// parse TeamCity's XML or JSON error body before falling back to treating
+// the body as a plain-text message.
+func newResponseError(statusCode int, path string, body []byte) *Error {
+	e := &Error{StatusCode: statusCode, Path: path}
+
+	var xmlBody teamCityErrorBody
+	if err := xml.Unmarshal(body, &xmlBody); err == nil && len(xmlBody.Errors) > 0 {
+		e.Message = xmlBody.Errors[0]
+		return e
+	}
+
+	var jsonBody teamCityErrorJSON
+	if err := json.Unmarshal(body, &jsonBody); err == nil && jsonBody.Error != "" {
+		e.Message = jsonBody.Error
+		e.Code = jsonBody.Code
+		e.Details = jsonBody.Details
+		return e
+	}
+
+	e.Message = truncate(string(body), 1000)
+	return e
+}
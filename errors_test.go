@@ -0,0 +1,111 @@
+package teamcity
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestNewResponseError_ParsesXML(t *testing.T) {
+	body := []byte(`<errors><error>Build not found</error></errors>`)
+	err := newResponseError(http.StatusNotFound, "/app/rest/builds/id:1", body)
+
+	if err.Message != "Build not found" {
+		t.Fatalf("Message = %q, want %q", err.Message, "Build not found")
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("errors.Is(err, ErrNotFound) = false, want true")
+	}
+}
+
+func TestNewResponseError_ParsesJSON(t *testing.T) {
+	body := []byte(`{"error":"bad request","errorCode":"E1","details":"oops"}`)
+	err := newResponseError(http.StatusBadRequest, "/app/rest/buildQueue", body)
+
+	if err.Message != "bad request" || err.Code != "E1" || err.Details != "oops" {
+		t.Fatalf("unexpected fields: %+v", err)
+	}
+}
+
+func TestNewResponseError_FallsBackToPlainText(t *testing.T) {
+	body := []byte("internal server error, try again later")
+	err := newResponseError(http.StatusInternalServerError, "/app/rest/builds/id:1", body)
+
+	if err.Message != string(body) {
+		t.Fatalf("Message = %q, want %q", err.Message, body)
+	}
+	if !errors.Is(err, ErrServer) {
+		t.Fatalf("errors.Is(err, ErrServer) = false, want true")
+	}
+}
+
+func TestError_IsSentinels(t *testing.T) {
+	cases := []struct {
+		status int
+		target error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusServiceUnavailable, ErrServer},
+	}
+
+	for _, c := range cases {
+		err := &Error{StatusCode: c.status}
+		if !errors.Is(err, c.target) {
+			t.Errorf("status %d: errors.Is(err, %v) = false, want true", c.status, c.target)
+		}
+	}
+}
+
+func TestWithRetry_StopsOnNonTransient4xx(t *testing.T) {
+	calls := 0
+	client := &Client{
+		auth: BasicAuth{User: "u", Pass: "p"},
+		host: "host.example.com",
+		HTTPClient: &http.Client{
+			Transport: RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				return newResponse(http.StatusNotFound, "not found"), nil
+			}),
+		},
+	}
+
+	err := client.withRetry(context.Background(), 8, func() error {
+		return client.doRequest(context.Background(), "GET", "/app/rest/builds/id:1", nil, nil)
+	})
+
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1 (no retries on 404)", calls)
+	}
+}
+
+func TestWithRetry_RetriesTransient5xx(t *testing.T) {
+	calls := 0
+	client := &Client{
+		auth: BasicAuth{User: "u", Pass: "p"},
+		host: "host.example.com",
+		HTTPClient: &http.Client{
+			Transport: RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				return newResponse(http.StatusServiceUnavailable, "unavailable"), nil
+			}),
+		},
+	}
+
+	err := client.withRetry(context.Background(), 3, func() error {
+		return client.doRequest(context.Background(), "GET", "/app/rest/builds/id:1", nil, nil)
+	})
+
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3 (retries exhausted on transient 5xx)", calls)
+	}
+}
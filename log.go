@@ -0,0 +1,177 @@
+package teamcity
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// LogChunk is a slice of newly-appended build log bytes emitted by
+// FollowBuildLog, along with the log offset immediately after it.
+type LogChunk struct {
+	Data   []byte
+	Offset int64
+}
+
+// FollowOptions configures FollowBuildLog.
+type FollowOptions struct {
+	// PollInterval is how often to re-fetch the log looking for new
+	// bytes. Defaults to 2s if zero.
+	PollInterval time.Duration
+	// InitialOffset starts tailing from this byte offset instead of the
+	// beginning of the log.
+	InitialOffset int64
+	// LineBuffered splits emitted chunks on '\n' boundaries instead of
+	// emitting whatever happened to be read off the wire.
+	LineBuffered bool
+}
+
+// OpenBuildLog returns the build log response body directly for
+// streaming, instead of buffering the whole log into memory the way
+// GetBuildLog does. The caller must close it.
+func (c *Client) OpenBuildLog(ctx context.Context, buildID string) (io.ReadCloser, error) {
+	return c.openBuildLogRange(ctx, buildID, 0)
+}
+
+// FollowBuildLog polls downloadBuildLog.html for newly-appended bytes
+// using a Range request, emitting them on the returned channel until the
+// build reaches a terminal state or ctx is cancelled. At most one error
+// is sent on the error channel before both channels are closed.
+func (c *Client) FollowBuildLog(ctx context.Context, buildID string, opts FollowOptions) (<-chan LogChunk, <-chan error) {
+	chunks := make(chan LogChunk)
+	errc := make(chan error, 1)
+
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = 2 * time.Second
+	}
+
+	go func() {
+		defer close(chunks)
+		defer close(errc)
+
+		offset := opts.InitialOffset
+		var pending bytes.Buffer
+
+		for {
+			body, err := c.openBuildLogRange(ctx, buildID, offset)
+			if err != nil {
+				errc <- err
+				return
+			}
+			data, err := ioutil.ReadAll(body)
+			body.Close()
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			if len(data) > 0 {
+				offset += int64(len(data))
+
+				if !opts.LineBuffered {
+					if !sendChunk(ctx, chunks, errc, LogChunk{Data: data, Offset: offset}) {
+						return
+					}
+				} else {
+					pending.Write(data)
+					for {
+						line, err := pending.ReadBytes('\n')
+						if err != nil {
+							// incomplete line; keep it for the next poll
+							pending.Reset()
+							pending.Write(line)
+							break
+						}
+						if !sendChunk(ctx, chunks, errc, LogChunk{Data: line, Offset: offset - int64(pending.Len())}) {
+							return
+						}
+					}
+				}
+			}
+
+			build, err := c.GetBuildContext(ctx, buildID)
+			if err != nil {
+				errc <- err
+				return
+			}
+			if build.State == "finished" {
+				if opts.LineBuffered && pending.Len() > 0 {
+					sendChunk(ctx, chunks, errc, LogChunk{Data: pending.Bytes(), Offset: offset})
+				}
+				return
+			}
+
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return chunks, errc
+}
+
+// sendChunk delivers chunk to chunks, reporting ctx cancellation on errc
+// and returning false if the caller should stop following.
+func sendChunk(ctx context.Context, chunks chan<- LogChunk, errc chan<- error, chunk LogChunk) bool {
+	select {
+	case chunks <- chunk:
+		return true
+	case <-ctx.Done():
+		errc <- ctx.Err()
+		return false
+	}
+}
+
+// openBuildLogRange requests the build log starting at offset via a
+// Range header, returning the response body unread for streaming.
+func (c *Client) openBuildLogRange(ctx context.Context, buildID string, offset int64) (io.ReadCloser, error) {
+	path := fmt.Sprintf("/downloadBuildLog.html?buildId=%s", buildID)
+	authURL := c.addProtocol(c.authPrefix() + path)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", authURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.auth.Apply(req); err != nil {
+		return nil, fmt.Errorf("applying authenticator: %s", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		if refresher, ok := c.auth.(*RefreshingAuthenticator); ok {
+			if rerr := refresher.refreshOn401(); rerr == nil {
+				resp.Body.Close()
+				retry := req.Clone(ctx)
+				if err := c.auth.Apply(retry); err != nil {
+					return nil, fmt.Errorf("applying authenticator: %s", err)
+				}
+				if resp, err = c.HTTPClient.Do(retry); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, newResponseError(resp.StatusCode, path, body)
+	}
+
+	return resp.Body, nil
+}
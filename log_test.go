@@ -0,0 +1,85 @@
+package teamcity
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFollowBuildLog_FlushesTrailingPartialLine(t *testing.T) {
+	client := &Client{
+		auth: BasicAuth{User: "u", Pass: "p"},
+		host: "host.example.com",
+		HTTPClient: &http.Client{
+			Transport: RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+				switch {
+				case strings.Contains(req.URL.Path, "downloadBuildLog.html"):
+					return newResponse(http.StatusOK, "line one\nline two (no trailing newline)"), nil
+				case strings.Contains(req.URL.Path, "/app/rest/builds/id:"):
+					return newResponse(http.StatusOK, `{"state":"finished"}`), nil
+				default:
+					t.Fatalf("unexpected request path: %s", req.URL.Path)
+					return nil, nil
+				}
+			}),
+		},
+	}
+
+	chunks, errc := client.FollowBuildLog(context.Background(), "123", FollowOptions{
+		LineBuffered: true,
+		PollInterval: time.Millisecond,
+	})
+
+	var got []string
+	for chunk := range chunks {
+		got = append(got, string(chunk.Data))
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"line one\n", "line two (no trailing newline)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestFollowBuildLog_UnbufferedEmitsRawChunks(t *testing.T) {
+	client := &Client{
+		auth: BasicAuth{User: "u", Pass: "p"},
+		host: "host.example.com",
+		HTTPClient: &http.Client{
+			Transport: RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+				switch {
+				case strings.Contains(req.URL.Path, "downloadBuildLog.html"):
+					return newResponse(http.StatusOK, "partial output, no newline"), nil
+				case strings.Contains(req.URL.Path, "/app/rest/builds/id:"):
+					return newResponse(http.StatusOK, `{"state":"finished"}`), nil
+				default:
+					t.Fatalf("unexpected request path: %s", req.URL.Path)
+					return nil, nil
+				}
+			}),
+		},
+	}
+
+	chunks, errc := client.FollowBuildLog(context.Background(), "123", FollowOptions{
+		PollInterval: time.Millisecond,
+	})
+
+	var got []string
+	for chunk := range chunks {
+		got = append(got, string(chunk.Data))
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"partial output, no newline"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
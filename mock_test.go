@@ -2,16 +2,14 @@ package teamcity
 
 import (
 	"bytes"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 )
 
 func NewTestClient(replyResp *http.Response, err error) *Client {
 	client := &Client{
-		username: "username",
-		password: "password",
-		host:     "host.example.com",
+		auth: BasicAuth{User: "username", Pass: "password"},
+		host: "host.example.com",
 	}
 	httpClient := &http.Client{}
 	httpClient.Transport = &MockTransport{
@@ -30,10 +28,21 @@ type MockTransport struct {
 
 func (b *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	b.req = req
-	fmt.Println("MAMAMAA", req)
 	return b.resp, b.err
 }
 
-func newResponse(body string) *http.Response {
-	return &http.Response{Body: ioutil.NopCloser(bytes.NewBuffer([]byte(body)))}
+func newResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Body:       ioutil.NopCloser(bytes.NewBuffer([]byte(body))),
+	}
+}
+
+// RoundTripFunc adapts a plain function to an http.RoundTripper, for
+// tests that need per-call behavior (sequenced responses, asserting on
+// headers, etc.) that the single-response MockTransport can't express.
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
 }
@@ -0,0 +1,135 @@
+// Package report converts TeamCity build results into standard
+// test-result interchange formats consumable by downstream tooling:
+// JUnit XML and SubUnit v2. It has no dependency on the teamcity package
+// itself (that would create an import cycle, since teamcity depends on
+// report for Client.GetBuildReport) — callers convert their
+// teamcity.TestOccurrence/teamcity.ProblemOccurrence values into the
+// TestResult/ProblemResult types below before handing them to the
+// Write* functions.
+package report
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+)
+
+// Format identifies an output format accepted by Client.GetBuildReport.
+type Format int
+
+const (
+	FormatJUnitXML Format = iota
+	FormatSubunitV2
+)
+
+// BuildInfo is the subset of a TeamCity build needed to label a report.
+type BuildInfo struct {
+	BuildTypeID string
+}
+
+// TestResult is a report-package-local view of a TeamCity test
+// occurrence. Timestamp and RouteCode are optional (zero value means
+// "unknown") and are omitted from SubUnit v2 output when unset.
+type TestResult struct {
+	Name      string
+	Status    string
+	Duration  int64 // milliseconds
+	Details   string
+	Timestamp time.Time
+	RouteCode string
+}
+
+// ProblemResult is a report-package-local view of a TeamCity build
+// problem occurrence.
+type ProblemResult struct {
+	Type     string
+	Identity string
+	Details  string
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      float64     `xml:"time,attr"`
+	TestCases []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+type junitSkipped struct{}
+
+// WriteJUnitXML writes tests and problems as a <testsuites><testsuite>
+// JUnit document. TeamCity's FAILURE status becomes a <failure>,
+// IGNORED/MUTED becomes <skipped>; problems (which have no per-test
+// duration) are reported as failed testcases of their own so they aren't
+// silently dropped from the suite.
+func WriteJUnitXML(w io.Writer, build BuildInfo, tests []TestResult, problems []ProblemResult) error {
+	suite := junitSuite{Name: build.BuildTypeID}
+
+	for _, t := range tests {
+		classname, name := splitTestName(t.Name)
+		tc := junitCase{
+			ClassName: classname,
+			Name:      name,
+			Time:      float64(t.Duration) / 1000,
+		}
+		switch t.Status {
+		case "FAILURE":
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: "test failed", Content: t.Details}
+		case "IGNORED", "MUTED":
+			suite.Skipped++
+			tc.Skipped = &junitSkipped{}
+		}
+		suite.Tests++
+		suite.Time += tc.Time
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	for _, p := range problems {
+		suite.Tests++
+		suite.Failures++
+		suite.TestCases = append(suite.TestCases, junitCase{
+			ClassName: build.BuildTypeID,
+			Name:      p.Type,
+			Failure:   &junitFailure{Message: p.Identity, Content: p.Details},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(junitTestSuites{Suites: []junitSuite{suite}})
+}
+
+// splitTestName splits a dotted TeamCity test name ("pkg.Class.method")
+// into a JUnit classname and the remaining test name.
+func splitTestName(name string) (classname, short string) {
+	idx := strings.LastIndex(name, ".")
+	if idx < 0 {
+		return "", name
+	}
+	return name[:idx], name[idx+1:]
+}
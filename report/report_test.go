@@ -0,0 +1,102 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+)
+
+type parsedSuites struct {
+	XMLName xml.Name      `xml:"testsuites"`
+	Suites  []parsedSuite `xml:"testsuite"`
+}
+
+type parsedSuite struct {
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Cases    []parsedCase `xml:"testcase"`
+}
+
+type parsedCase struct {
+	ClassName string          `xml:"classname,attr"`
+	Name      string          `xml:"name,attr"`
+	Failure   *parsedFailure  `xml:"failure"`
+	Skipped   *struct{}       `xml:"skipped"`
+}
+
+type parsedFailure struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+func TestWriteJUnitXML(t *testing.T) {
+	build := BuildInfo{BuildTypeID: "MyBuild"}
+	tests := []TestResult{
+		{Name: "pkg.sub.TestFoo", Status: "SUCCESS", Duration: 1500},
+		{Name: "pkg.sub.TestBar", Status: "FAILURE", Duration: 500, Details: "assertion failed"},
+		{Name: "pkg.sub.TestBaz", Status: "IGNORED"},
+	}
+	problems := []ProblemResult{
+		{Type: "OOM", Identity: "abc123", Details: "heap exhausted"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJUnitXML(&buf, build, tests, problems); err != nil {
+		t.Fatalf("WriteJUnitXML: %v", err)
+	}
+
+	var parsed parsedSuites
+	if err := xml.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("unmarshal: %v\n%s", err, buf.String())
+	}
+
+	if len(parsed.Suites) != 1 {
+		t.Fatalf("suites = %d, want 1", len(parsed.Suites))
+	}
+	suite := parsed.Suites[0]
+
+	if suite.Name != "MyBuild" {
+		t.Errorf("suite name = %q, want MyBuild", suite.Name)
+	}
+	if suite.Tests != 4 {
+		t.Errorf("tests = %d, want 4", suite.Tests)
+	}
+	if suite.Failures != 2 {
+		t.Errorf("failures = %d, want 2", suite.Failures)
+	}
+	if suite.Skipped != 1 {
+		t.Errorf("skipped = %d, want 1", suite.Skipped)
+	}
+	if len(suite.Cases) != 4 {
+		t.Fatalf("cases = %d, want 4", len(suite.Cases))
+	}
+
+	foo := suite.Cases[0]
+	if foo.ClassName != "pkg.sub" || foo.Name != "TestFoo" {
+		t.Errorf("foo classname/name = %q/%q, want pkg.sub/TestFoo", foo.ClassName, foo.Name)
+	}
+	if foo.Failure != nil || foo.Skipped != nil {
+		t.Errorf("foo should be neither failed nor skipped: %+v", foo)
+	}
+
+	bar := suite.Cases[1]
+	if bar.ClassName != "pkg.sub" || bar.Name != "TestBar" {
+		t.Errorf("bar classname/name = %q/%q, want pkg.sub/TestBar", bar.ClassName, bar.Name)
+	}
+	if bar.Failure == nil || bar.Failure.Content != "assertion failed" {
+		t.Errorf("bar failure = %+v, want content %q", bar.Failure, "assertion failed")
+	}
+
+	baz := suite.Cases[2]
+	if baz.Skipped == nil {
+		t.Errorf("baz should be skipped, got %+v", baz)
+	}
+
+	problem := suite.Cases[3]
+	if problem.Name != "OOM" || problem.Failure == nil ||
+		problem.Failure.Message != "abc123" || problem.Failure.Content != "heap exhausted" {
+		t.Errorf("problem case = %+v, want Name=OOM Failure.Message=abc123 Content=%q", problem, "heap exhausted")
+	}
+}
@@ -0,0 +1,156 @@
+package report
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// SubUnit v2 packet framing, per the subunit project's binary protocol:
+// a 1-byte signature, a 2-byte flags field (version + which optional
+// fields are present + test status), a 3-byte packet length covering the
+// whole packet including the trailing CRC32, the optional fields
+// themselves, and a CRC32 over everything preceding it.
+const subunitV2Signature = 0xb3
+
+// Subunit v2 test statuses occupy the low 3 bits of the flags field
+// (mask statusMask); the flag bits below are all above that range so
+// they can never collide with a status value.
+const statusMask = 0x0007
+
+// flagVersion2 puts protocol version 2 in the top four bits of the flags.
+const flagVersion2 = 0x2000
+
+const (
+	flagTestID      = 0x0010
+	flagRouteCode   = 0x0020
+	flagTimestamp   = 0x0040
+	flagRunnable    = 0x0080
+	flagFileContent = 0x0100
+	flagMIMEType    = 0x0200
+)
+
+const (
+	statusSuccess = 3
+	statusSkip    = 5
+	statusFail    = 6
+)
+
+// WriteSubunitV2 emits tests and problems as a stream of subunit v2
+// test_status packets: one packet per test carrying its status, test id,
+// timestamp and route code (when known), and, whenever Details is
+// non-empty (whatever the status), a file content section holding the
+// stack trace/details.
+func WriteSubunitV2(w io.Writer, build BuildInfo, tests []TestResult, problems []ProblemResult) error {
+	for _, t := range tests {
+		status := statusSuccess
+		switch t.Status {
+		case "FAILURE":
+			status = statusFail
+		case "IGNORED", "MUTED":
+			status = statusSkip
+		}
+		if err := writeSubunitPacket(w, t.Name, status, t.RouteCode, t.Timestamp, t.Details); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range problems {
+		if err := writeSubunitPacket(w, p.Type, statusFail, "", time.Time{}, p.Details); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSubunitPacket encodes a single test_status packet for the named
+// test/problem, optionally attaching a route code, a timestamp, and its
+// details as a "traceback" file content section.
+func writeSubunitPacket(w io.Writer, testID string, status int, routeCode string, timestamp time.Time, details string) error {
+	var body bytes.Buffer
+
+	flags := uint16(status&statusMask) | flagVersion2 | flagTestID | flagRunnable
+
+	if !timestamp.IsZero() {
+		flags |= flagTimestamp
+		writeTimestamp(&body, timestamp)
+	}
+
+	writePacked(&body, testID)
+
+	if routeCode != "" {
+		flags |= flagRouteCode
+		writePacked(&body, routeCode)
+	}
+
+	if details != "" {
+		flags |= flagFileContent | flagMIMEType
+		writePacked(&body, "traceback")
+		writePacked(&body, "text/plain;charset=utf8")
+		writePacked(&body, details)
+	}
+
+	var packet bytes.Buffer
+	packet.WriteByte(subunitV2Signature)
+	packet.WriteByte(byte(flags >> 8))
+	packet.WriteByte(byte(flags))
+	// packet length placeholder; patched below once it's known
+	packet.Write([]byte{0, 0, 0})
+	packet.Write(body.Bytes())
+
+	out := packet.Bytes()
+	length := len(out) + 4 // + trailing CRC32
+	out[3] = byte(length >> 16)
+	out[4] = byte(length >> 8)
+	out[5] = byte(length)
+
+	crc := crc32.ChecksumIEEE(out)
+
+	if _, err := w.Write(out); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)})
+	return err
+}
+
+// writeTimestamp encodes a subunit v2 timestamp: 4-byte big-endian
+// seconds since the epoch followed by a packed nanosecond remainder.
+func writeTimestamp(buf *bytes.Buffer, t time.Time) {
+	sec := uint32(t.Unix())
+	buf.WriteByte(byte(sec >> 24))
+	buf.WriteByte(byte(sec >> 16))
+	buf.WriteByte(byte(sec >> 8))
+	buf.WriteByte(byte(sec))
+	writePackedInt(buf, t.Nanosecond())
+}
+
+// writePacked encodes a UTF-8 string as a subunit "packed" length-prefixed
+// field: a varint byte count followed by the raw bytes.
+func writePacked(buf *bytes.Buffer, s string) {
+	writePackedInt(buf, len(s))
+	buf.WriteString(s)
+}
+
+// writePackedInt encodes a non-negative integer using subunit's packed
+// integer format: the top two bits of the first byte hold the count of
+// additional bytes (0-3), the rest is the big-endian value.
+func writePackedInt(buf *bytes.Buffer, v int) {
+	switch {
+	case v < 1<<6:
+		buf.WriteByte(byte(v))
+	case v < 1<<14:
+		buf.WriteByte(0x40 | byte(v>>8))
+		buf.WriteByte(byte(v))
+	case v < 1<<22:
+		buf.WriteByte(0x80 | byte(v>>16))
+		buf.WriteByte(byte(v >> 8))
+		buf.WriteByte(byte(v))
+	default:
+		buf.WriteByte(0xc0 | byte(v>>24))
+		buf.WriteByte(byte(v >> 16))
+		buf.WriteByte(byte(v >> 8))
+		buf.WriteByte(byte(v))
+	}
+}
@@ -0,0 +1,237 @@
+package report
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"testing"
+	"time"
+)
+
+// decodedPacket is the test-side inverse of writeSubunitPacket, used to
+// assert on what actually landed on the wire rather than trusting the
+// encoder's own bookkeeping.
+type decodedPacket struct {
+	Flags        uint16
+	Status       int
+	TestID       string
+	HasRouteCode bool
+	RouteCode    string
+	HasTimestamp bool
+	Timestamp    time.Time
+	Traceback    string
+}
+
+func decodeSubunitPackets(t *testing.T, data []byte) []decodedPacket {
+	t.Helper()
+
+	var packets []decodedPacket
+	r := bytes.NewReader(data)
+
+	for r.Len() > 0 {
+		sig, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("reading signature: %v", err)
+		}
+		if sig != subunitV2Signature {
+			t.Fatalf("bad signature: %#x", sig)
+		}
+
+		var header [5]byte // 2 bytes flags + 3 bytes length
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			t.Fatalf("reading header: %v", err)
+		}
+		flags := uint16(header[0])<<8 | uint16(header[1])
+		length := int(header[2])<<16 | int(header[3])<<8 | int(header[4])
+
+		rest := make([]byte, length-6)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			t.Fatalf("reading packet body: %v", err)
+		}
+
+		payload, wantCRC := rest[:len(rest)-4], rest[len(rest)-4:]
+		gotCRC := crc32.ChecksumIEEE(append([]byte{sig, header[0], header[1], header[2], header[3], header[4]}, payload...))
+		if byte(gotCRC>>24) != wantCRC[0] || byte(gotCRC>>16) != wantCRC[1] ||
+			byte(gotCRC>>8) != wantCRC[2] || byte(gotCRC) != wantCRC[3] {
+			t.Fatalf("crc32 mismatch for packet with flags %016b", flags)
+		}
+
+		pk := decodedPacket{Flags: flags, Status: int(flags & statusMask)}
+		body := bytes.NewReader(payload)
+
+		if flags&flagTimestamp != 0 {
+			pk.HasTimestamp = true
+			pk.Timestamp = readTimestamp(t, body)
+		}
+		if flags&flagTestID != 0 {
+			pk.TestID = readPackedString(t, body)
+		}
+		if flags&flagRouteCode != 0 {
+			pk.HasRouteCode = true
+			pk.RouteCode = readPackedString(t, body)
+		}
+		if flags&flagFileContent != 0 {
+			name := readPackedString(t, body)
+			if name != "traceback" {
+				t.Fatalf("file content name = %q, want traceback", name)
+			}
+			if flags&flagMIMEType != 0 {
+				readPackedString(t, body) // mime type, unchecked
+			}
+			pk.Traceback = readPackedString(t, body)
+		}
+
+		if body.Len() != 0 {
+			t.Fatalf("%d unconsumed trailing bytes in packet body (flags %016b) — fields decoded out of sync", body.Len(), flags)
+		}
+
+		packets = append(packets, pk)
+	}
+
+	return packets
+}
+
+func readTimestamp(t *testing.T, r *bytes.Reader) time.Time {
+	t.Helper()
+	var secBytes [4]byte
+	if _, err := io.ReadFull(r, secBytes[:]); err != nil {
+		t.Fatalf("reading timestamp seconds: %v", err)
+	}
+	sec := int64(secBytes[0])<<24 | int64(secBytes[1])<<16 | int64(secBytes[2])<<8 | int64(secBytes[3])
+	nsec := readPackedInt(t, r)
+	return time.Unix(sec, int64(nsec)).UTC()
+}
+
+func readPackedString(t *testing.T, r *bytes.Reader) string {
+	t.Helper()
+	n := readPackedInt(t, r)
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("reading packed string of length %d: %v", n, err)
+	}
+	return string(buf)
+}
+
+func readPackedInt(t *testing.T, r *bytes.Reader) int {
+	t.Helper()
+	b0, err := r.ReadByte()
+	if err != nil {
+		t.Fatalf("reading packed int: %v", err)
+	}
+
+	extra := int(b0 >> 6)
+	v := int(b0 & 0x3f)
+	for i := 0; i < extra; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("reading packed int continuation: %v", err)
+		}
+		v = v<<8 | int(b)
+	}
+	return v
+}
+
+func TestWriteSubunitV2_FailureDoesNotSetRouteCodeFlag(t *testing.T) {
+	var buf bytes.Buffer
+	tests := []TestResult{
+		{Name: "pkg.TestFoo", Status: "FAILURE", Details: "boom"},
+	}
+	if err := WriteSubunitV2(&buf, BuildInfo{}, tests, nil); err != nil {
+		t.Fatalf("WriteSubunitV2: %v", err)
+	}
+
+	packets := decodeSubunitPackets(t, buf.Bytes())
+	if len(packets) != 1 {
+		t.Fatalf("packets = %d, want 1", len(packets))
+	}
+	pk := packets[0]
+
+	if pk.Status != statusFail {
+		t.Errorf("status = %d, want %d", pk.Status, statusFail)
+	}
+	if pk.TestID != "pkg.TestFoo" {
+		t.Errorf("testID = %q, want pkg.TestFoo", pk.TestID)
+	}
+	if pk.HasRouteCode {
+		t.Errorf("flags %016b have route-code bit set despite RouteCode being empty", pk.Flags)
+	}
+	if pk.Traceback != "boom" {
+		t.Errorf("traceback = %q, want boom", pk.Traceback)
+	}
+}
+
+func TestWriteSubunitV2_SkipDoesNotSetRouteCodeFlag(t *testing.T) {
+	var buf bytes.Buffer
+	tests := []TestResult{
+		{Name: "pkg.TestIgnored", Status: "IGNORED"},
+	}
+	if err := WriteSubunitV2(&buf, BuildInfo{}, tests, nil); err != nil {
+		t.Fatalf("WriteSubunitV2: %v", err)
+	}
+
+	packets := decodeSubunitPackets(t, buf.Bytes())
+	if len(packets) != 1 {
+		t.Fatalf("packets = %d, want 1", len(packets))
+	}
+	pk := packets[0]
+
+	if pk.Status != statusSkip {
+		t.Errorf("status = %d, want %d", pk.Status, statusSkip)
+	}
+	if pk.HasRouteCode {
+		t.Errorf("flags %016b have route-code bit set despite RouteCode being empty", pk.Flags)
+	}
+}
+
+func TestWriteSubunitV2_RouteCodeAndTimestamp(t *testing.T) {
+	ts := time.Unix(1700000000, 123000000).UTC()
+	var buf bytes.Buffer
+	tests := []TestResult{
+		{Name: "pkg.TestBar", Status: "SUCCESS", RouteCode: "worker-1", Timestamp: ts},
+	}
+	if err := WriteSubunitV2(&buf, BuildInfo{}, tests, nil); err != nil {
+		t.Fatalf("WriteSubunitV2: %v", err)
+	}
+
+	packets := decodeSubunitPackets(t, buf.Bytes())
+	if len(packets) != 1 {
+		t.Fatalf("packets = %d, want 1", len(packets))
+	}
+	pk := packets[0]
+
+	if pk.Status != statusSuccess {
+		t.Errorf("status = %d, want %d", pk.Status, statusSuccess)
+	}
+	if !pk.HasRouteCode || pk.RouteCode != "worker-1" {
+		t.Errorf("routeCode = (present=%v, %q), want (true, worker-1)", pk.HasRouteCode, pk.RouteCode)
+	}
+	if !pk.HasTimestamp || !pk.Timestamp.Equal(ts) {
+		t.Errorf("timestamp = (present=%v, %v), want (true, %v)", pk.HasTimestamp, pk.Timestamp, ts)
+	}
+}
+
+func TestWriteSubunitV2_Problem(t *testing.T) {
+	var buf bytes.Buffer
+	problems := []ProblemResult{
+		{Type: "OOM", Identity: "abc123", Details: "heap exhausted"},
+	}
+	if err := WriteSubunitV2(&buf, BuildInfo{}, nil, problems); err != nil {
+		t.Fatalf("WriteSubunitV2: %v", err)
+	}
+
+	packets := decodeSubunitPackets(t, buf.Bytes())
+	if len(packets) != 1 {
+		t.Fatalf("packets = %d, want 1", len(packets))
+	}
+	pk := packets[0]
+
+	if pk.Status != statusFail {
+		t.Errorf("status = %d, want %d", pk.Status, statusFail)
+	}
+	if pk.TestID != "OOM" {
+		t.Errorf("testID = %q, want OOM", pk.TestID)
+	}
+	if pk.Traceback != "heap exhausted" {
+		t.Errorf("traceback = %q, want %q", pk.Traceback, "heap exhausted")
+	}
+}
@@ -0,0 +1,69 @@
+package teamcity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+)
+
+// Logger is satisfied by the standard library's *log.Logger, letting
+// embedding applications redirect retry diagnostics (see
+// Client.SetLogger) into structured loggers like zap/zerolog via a small
+// compatibility shim instead of the default global logger.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// RequestTracer observes outgoing requests and their responses. It is
+// only invoked while debug mode is enabled; see Client.SetRequestTracer
+// and Client.SetDebug.
+type RequestTracer interface {
+	TraceRequest(method, url string, body []byte)
+	TraceResponse(status int, body []byte, err error)
+}
+
+// fileTracer is a RequestTracer that writes each request/response to its
+// own file under dir.
+type fileTracer struct {
+	dir    string
+	logger Logger
+
+	mu  sync.Mutex
+	seq int
+}
+
+// FileTracer returns a RequestTracer that writes each request and its
+// response to a separate file under dir, named by a monotonically
+// increasing sequence number so concurrent requests don't clobber each
+// other. dir must already exist. If a file write fails, the error is
+// reported to logger (when non-nil, e.g. the same Logger passed to
+// Client.SetLogger) instead of the global log package; a nil logger
+// drops the error.
+func FileTracer(dir string, logger Logger) RequestTracer {
+	return &fileTracer{dir: dir, logger: logger}
+}
+
+func (t *fileTracer) TraceRequest(method, url string, body []byte) {
+	t.write("request", fmt.Sprintf("%s %s\n\n%s", method, url, body))
+}
+
+func (t *fileTracer) TraceResponse(status int, body []byte, err error) {
+	if err != nil {
+		t.write("response", fmt.Sprintf("error: %s", err))
+		return
+	}
+	t.write("response", fmt.Sprintf("status: %d\n\n%s", status, body))
+}
+
+func (t *fileTracer) write(kind, content string) {
+	t.mu.Lock()
+	t.seq++
+	seq := t.seq
+	t.mu.Unlock()
+
+	name := filepath.Join(t.dir, fmt.Sprintf("%d-%s.txt", seq, kind))
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil && t.logger != nil {
+		t.logger.Printf("teamcity: file tracer: %s", err)
+	}
+}